@@ -0,0 +1,135 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package buildah builds and commits images the way buildah does: against
+// a working container on the filesystem, with no docker daemon involved,
+// so it can run unprivileged (user namespaces) on build hosts that don't
+// have dockerd. It is a drop-in alternative to provision/docker's
+// container.commit, selected with build:backend=buildah.
+package buildah
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/log"
+	"github.com/satori/go.uuid"
+)
+
+// Builder holds a working container: an extracted copy of an image's
+// rootfs as the overlay lowerdir, an upperdir recording every change made
+// on top of it, plus the config that Commit will bake into the resulting
+// image.
+type Builder struct {
+	id         string
+	fromImage  string
+	entrypoint []string
+	cmd        []string
+	env        []string
+	labels     map[string]string
+	rootDir    string
+}
+
+// New allocates a working container with a fresh id, rooted under the
+// containers-storage tree returned by Root.
+func New() (*Builder, error) {
+	id := uuid.NewV4().String()
+	root := filepath.Join(Root(), "working-containers", id)
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Builder{id: id, rootDir: root}, nil
+}
+
+// ID returns the working-container id.
+func (b *Builder) ID() string {
+	return b.id
+}
+
+// Enabled reports whether build:backend is set to "buildah", in which
+// case deploy pipelines should replace their docker commit + docker push
+// pair with Builder.Commit followed by Push.
+func Enabled() bool {
+	backend, _ := config.GetString("build:backend")
+	return backend == "buildah"
+}
+
+// From extracts image's rootfs into the working container's lowerdir,
+// mirroring `buildah from`.
+func (b *Builder) From(image string) error {
+	b.fromImage = image
+	lower := filepath.Join(b.rootDir, "lower")
+	if err := os.MkdirAll(lower, 0755); err != nil {
+		return err
+	}
+	log.Printf("buildah: extracting %s into %s", image, lower)
+	return extractImage(image, lower)
+}
+
+// Run executes cmd inside the working container, recording its effect on
+// the overlay upperdir so Commit can snapshot it as a new layer. cmd runs
+// chrooted into the merged view under a fresh user/mount/uts/ipc/pid
+// namespace (via unshare), not just with its working directory pointed at
+// it, so it sees the working container's rootfs instead of the build
+// host's.
+func (b *Builder) Run(cmd ...string) error {
+	if err := b.mount(); err != nil {
+		return err
+	}
+	defer b.unmount()
+	log.Printf("buildah: running %v in %s", cmd, b.id)
+	unshareArgs := append([]string{
+		"--mount", "--uts", "--ipc", "--pid", "--fork",
+		"--user", "--map-root-user",
+		"chroot", b.merged(),
+	}, cmd...)
+	command := exec.Command("unshare", unshareArgs...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	return command.Run()
+}
+
+// Copy copies src from the build host into dst inside the working
+// container's merged view.
+func (b *Builder) Copy(src, dst string) error {
+	if err := b.mount(); err != nil {
+		return err
+	}
+	defer b.unmount()
+	target := filepath.Join(b.merged(), dst)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return copyFile(src, target)
+}
+
+// Config sets the image metadata Commit writes into the resulting
+// manifest's config.
+func (b *Builder) Config(entrypoint, cmd, env []string, labels map[string]string) {
+	b.entrypoint = entrypoint
+	b.cmd = cmd
+	b.env = env
+	b.labels = labels
+}
+
+// Commit snapshots the overlay upperdir as a new layer, builds an OCI
+// image manifest on top of the FROM image's layers, and writes both into
+// the local containers-storage tree under ref. Unlike `docker commit`,
+// this never talks to a daemon: the diff is computed straight from the
+// upperdir, which is what lets it run unprivileged.
+func (b *Builder) Commit(ref string) error {
+	layer, err := b.snapshotUpperdir()
+	if err != nil {
+		return fmt.Errorf("buildah: could not snapshot layer for %s: %s", b.id, err)
+	}
+	manifest, err := writeManifest(ref, b.fromImage, layer, b.entrypoint, b.cmd, b.env, b.labels)
+	if err != nil {
+		return fmt.Errorf("buildah: could not write manifest for %s: %s", ref, err)
+	}
+	log.Printf("buildah: committed %s as %s (manifest %s)", b.id, ref, manifest)
+	return nil
+}
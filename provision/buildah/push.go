@@ -0,0 +1,184 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ociImageConfig is the subset of the OCI image-spec config blob that
+// carries the metadata Builder.Config records.
+type ociImageConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       struct {
+		Entrypoint []string          `json:"Entrypoint,omitempty"`
+		Cmd        []string          `json:"Cmd,omitempty"`
+		Env        []string          `json:"Env,omitempty"`
+		Labels     map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+	RootFS struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociImageManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// refAnnotation is the only tag ever written into an index.json built by
+// Push; skopeo is told to copy that one tag, so it never has to match ref
+// itself, which may contain characters an OCI layout can't use as a tag.
+const refAnnotation = "latest"
+
+// Push publishes ref, committed earlier by Builder.Commit, to the registry
+// named in ref: it rebuilds ref's manifest as a real OCI image layout (the
+// local containers-storage tree only keeps tsuru's own simplified manifest
+// format, which nothing outside this package can pull) in a scratch
+// directory, then hands that layout to skopeo, which does the registry
+// media-type bookkeeping an actual push needs.
+func Push(ref string) error {
+	manifestPath := filepath.Join(Root(), "manifests", sanitizeRef(ref)+".json")
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("buildah: could not read manifest for %s: %s", ref, err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("buildah: could not parse manifest for %s: %s", ref, err)
+	}
+	layout, err := ioutil.TempDir("", "buildah-push-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(layout)
+	blobsDir := filepath.Join(layout, "blobs", "sha256")
+	if err := os.MkdirAll(blobsDir, 0755); err != nil {
+		return err
+	}
+	layerDescriptors := make([]ociDescriptor, 0, len(manifest.Layers))
+	diffIDs := make([]string, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		size, err := copyBlob(filepath.Join(Root(), "layers", layer+".tar"), filepath.Join(blobsDir, layer))
+		if err != nil {
+			return fmt.Errorf("buildah: could not stage layer %s for %s: %s", layer, ref, err)
+		}
+		layerDescriptors = append(layerDescriptors, ociDescriptor{
+			MediaType: "application/vnd.oci.image.layer.v1.tar",
+			Digest:    "sha256:" + layer,
+			Size:      size,
+		})
+		diffIDs = append(diffIDs, "sha256:"+layer)
+	}
+	var config ociImageConfig
+	config.Architecture = "amd64"
+	config.OS = "linux"
+	config.Config.Entrypoint = manifest.Config.Entrypoint
+	config.Config.Cmd = manifest.Config.Cmd
+	config.Config.Env = manifest.Config.Env
+	config.Config.Labels = manifest.Config.Labels
+	config.RootFS.Type = "layers"
+	config.RootFS.DiffIDs = diffIDs
+	configDigest, configSize, err := writeBlob(blobsDir, config)
+	if err != nil {
+		return fmt.Errorf("buildah: could not write config blob for %s: %s", ref, err)
+	}
+	imageManifest := ociImageManifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config: ociDescriptor{
+			MediaType: "application/vnd.oci.image.config.v1+json",
+			Digest:    "sha256:" + configDigest,
+			Size:      configSize,
+		},
+		Layers: layerDescriptors,
+	}
+	manifestDigest, manifestSize, err := writeBlob(blobsDir, imageManifest)
+	if err != nil {
+		return fmt.Errorf("buildah: could not write image manifest for %s: %s", ref, err)
+	}
+	index := ociIndex{
+		SchemaVersion: 2,
+		Manifests: []ociDescriptor{{
+			MediaType:   "application/vnd.oci.image.manifest.v1+json",
+			Digest:      "sha256:" + manifestDigest,
+			Size:        manifestSize,
+			Annotations: map[string]string{"org.opencontainers.image.ref.name": refAnnotation},
+		}},
+	}
+	if err := writeJSON(filepath.Join(layout, "index.json"), index); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(layout, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		return err
+	}
+	cmd := exec.Command("skopeo", "copy", "oci:"+layout+":"+refAnnotation, "docker://"+ref)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("buildah: could not push %s: %s", ref, err)
+	}
+	return nil
+}
+
+// copyBlob copies src into dst verbatim (layer tarballs are already named
+// by their own sha256 digest, so no hashing is needed here) and returns its
+// size for the resulting OCI descriptor.
+func copyBlob(src, dst string) (int64, error) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// writeBlob marshals v as a blob under blobsDir, named by its own sha256
+// digest, and returns that digest plus the blob's size for its descriptor.
+func writeBlob(blobsDir string, v interface{}) (digest string, size int64, err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", 0, err
+	}
+	sum, err := sha256Bytes(data)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(blobsDir, sum), data, 0644); err != nil {
+		return "", 0, err
+	}
+	return sum, int64(len(data)), nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
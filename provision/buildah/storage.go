@@ -0,0 +1,226 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildah
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/globocom/config"
+)
+
+// Root returns the local containers-storage tree, configurable under
+// build:buildah:storage-root and defaulting to /var/lib/containers/storage,
+// the path buildah itself uses.
+func Root() string {
+	root, err := config.GetString("build:buildah:storage-root")
+	if err != nil || root == "" {
+		root = "/var/lib/containers/storage"
+	}
+	return root
+}
+
+func (b *Builder) upperdir() string {
+	return filepath.Join(b.rootDir, "upper")
+}
+
+func (b *Builder) workdir() string {
+	return filepath.Join(b.rootDir, "work")
+}
+
+func (b *Builder) merged() string {
+	return filepath.Join(b.rootDir, "merged")
+}
+
+// mount stacks the working container's lowerdir (the FROM image) and
+// upperdir (everything Run/Copy have changed so far) into merged. It uses
+// fuse-overlayfs rather than the kernel's `mount -t overlay`, since the
+// latter needs CAP_SYS_ADMIN and would defeat the point of running
+// rootless.
+func (b *Builder) mount() error {
+	for _, dir := range []string{b.upperdir(), b.workdir(), b.merged()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	opts := "lowerdir=" + filepath.Join(b.rootDir, "lower") +
+		",upperdir=" + b.upperdir() + ",workdir=" + b.workdir()
+	return exec.Command("fuse-overlayfs", "-o", opts, b.merged()).Run()
+}
+
+// unmount tears down the fuse-overlayfs mount set up by mount.
+func (b *Builder) unmount() error {
+	return exec.Command("fusermount", "-u", b.merged()).Run()
+}
+
+// snapshotUpperdir tars up the overlay upperdir and returns the sha256 of
+// the tarball, which becomes the new layer's digest. This is how Commit
+// computes a layer diff without ever invoking `docker commit`.
+func (b *Builder) snapshotUpperdir() (string, error) {
+	layersDir := filepath.Join(Root(), "layers")
+	if err := os.MkdirAll(layersDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(layersDir, "layer-")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+	tarCmd := exec.Command("tar", "-C", b.upperdir(), "-cf", "-", ".")
+	tarCmd.Stdout = tmp
+	if err := tarCmd.Run(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	digest, err := sha256File(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	final := filepath.Join(layersDir, digest+".tar")
+	if err := os.Rename(tmp.Name(), final); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sha256Bytes(data []byte) (string, error) {
+	h := sha256.New()
+	if _, err := h.Write(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ociConfig and ociManifest are tsuru's own simplified manifest format,
+// just enough for this package's From/Commit to track a working
+// container's FROM image, layer and baked-in config. Push translates a
+// stored ociManifest into a real OCI image layout, with the full
+// media-type bookkeeping a registry push needs, which this format doesn't
+// carry on its own.
+type ociConfig struct {
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+type ociManifest struct {
+	FromImage string    `json:"fromImage"`
+	Layers    []string  `json:"layers"`
+	Config    ociConfig `json:"config"`
+}
+
+// writeManifest writes ref's manifest, listing fromImage's layer chain
+// plus the newly committed layer, into the containers-storage tree.
+func writeManifest(ref, fromImage, layer string, entrypoint, cmd, env []string, labels map[string]string) (string, error) {
+	manifest := ociManifest{
+		FromImage: fromImage,
+		Layers:    []string{layer},
+		Config: ociConfig{
+			Entrypoint: entrypoint,
+			Cmd:        cmd,
+			Env:        env,
+			Labels:     labels,
+		},
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	manifestsDir := filepath.Join(Root(), "manifests")
+	if err := os.MkdirAll(manifestsDir, 0755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(manifestsDir, sanitizeRef(ref)+".json")
+	return path, ioutil.WriteFile(path, data, 0644)
+}
+
+func sanitizeRef(ref string) string {
+	out := make([]rune, 0, len(ref))
+	for _, r := range ref {
+		if r == '/' || r == ':' {
+			r = '_'
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// ociDirLayout is the subset of an OCI dir: transport manifest.json that
+// extractImage needs to find image's layer blobs.
+type ociDirLayout struct {
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+}
+
+// extractImage populates dst with image's rootfs. It fetches image through
+// skopeo (into the OCI dir: transport, under a scratch directory) rather
+// than assuming a docker daemon already has it, then extracts each layer
+// blob from the fetched manifest into dst in order, so From works against
+// any image skopeo can reach, not just ones docker has already pulled.
+func extractImage(image, dst string) error {
+	fetchDir, err := ioutil.TempDir("", "buildah-fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(fetchDir)
+	src := "docker://" + image
+	if err := exec.Command("skopeo", "copy", src, "dir:"+fetchDir).Run(); err != nil {
+		return fmt.Errorf("could not fetch %s: %s", image, err)
+	}
+	manifestData, err := ioutil.ReadFile(filepath.Join(fetchDir, "manifest.json"))
+	if err != nil {
+		return fmt.Errorf("could not read manifest for %s: %s", image, err)
+	}
+	var layout ociDirLayout
+	if err := json.Unmarshal(manifestData, &layout); err != nil {
+		return fmt.Errorf("could not parse manifest for %s: %s", image, err)
+	}
+	for _, layer := range layout.Layers {
+		blob := filepath.Join(fetchDir, strings.TrimPrefix(layer.Digest, "sha256:"))
+		if err := exec.Command("tar", "-C", dst, "-xf", blob).Run(); err != nil {
+			return fmt.Errorf("could not extract layer %s of %s: %s", layer.Digest, image, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
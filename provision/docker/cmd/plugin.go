@@ -0,0 +1,92 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cmd provides the tsuru-admin commands used to manage
+// out-of-process container driver plugins installed under tsuru/plugins.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/globocom/tsuru/cmd"
+	"github.com/globocom/tsuru/provision/docker"
+)
+
+type PluginInstall struct{}
+
+func (PluginInstall) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "plugin-install",
+		Usage:   "plugin-install <manifest-path>",
+		Desc:    "Installs a container driver plugin from a JSON manifest (name, socket path, capabilities) and enables it.",
+		MinArgs: 1,
+	}
+}
+
+func (PluginInstall) Run(context *cmd.Context, client *cmd.Client) error {
+	path := context.Args[0]
+	if err := docker.InstallPlugin(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "plugin installed from %s\n", path)
+	return nil
+}
+
+type PluginEnable struct{}
+
+func (PluginEnable) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "plugin-enable",
+		Usage:   "plugin-enable <name>",
+		Desc:    "Enables a previously installed container driver plugin.",
+		MinArgs: 1,
+	}
+}
+
+func (PluginEnable) Run(context *cmd.Context, client *cmd.Client) error {
+	name := context.Args[0]
+	if err := docker.EnablePlugin(name); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "plugin %s enabled\n", name)
+	return nil
+}
+
+type PluginDisable struct{}
+
+func (PluginDisable) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "plugin-disable",
+		Usage:   "plugin-disable <name>",
+		Desc:    "Disables a container driver plugin, without removing its manifest.",
+		MinArgs: 1,
+	}
+}
+
+func (PluginDisable) Run(context *cmd.Context, client *cmd.Client) error {
+	name := context.Args[0]
+	docker.DisablePlugin(name)
+	fmt.Fprintf(context.Stdout, "plugin %s disabled\n", name)
+	return nil
+}
+
+type PluginRemove struct{}
+
+func (PluginRemove) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "plugin-remove",
+		Usage:   "plugin-remove <name>",
+		Desc:    "Disables a container driver plugin and deletes its manifest.",
+		MinArgs: 1,
+	}
+}
+
+func (PluginRemove) Run(context *cmd.Context, client *cmd.Client) error {
+	name := context.Args[0]
+	if err := docker.RemovePlugin(name); err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "plugin %s removed\n", name)
+	return nil
+}
@@ -6,13 +6,10 @@ package docker
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"github.com/globocom/config"
 	"github.com/globocom/tsuru/fs"
 	"github.com/globocom/tsuru/log"
-	"strings"
+	"github.com/globocom/tsuru/provision/docker/registry"
 )
 
 var fsystem fs.Fs
@@ -38,25 +35,22 @@ func runCmd(cmd string, args ...string) (string, error) {
 	return out.String(), err
 }
 
-// ip returns the ip for the container.
+// ip returns the ip for the container. It inspects the container through
+// the configured ContainerDriver, so with the HTTP driver this reads the
+// address straight from the Remote API response instead of parsing it
+// out of a CLI command's stdout.
 func (c *container) ip() (string, error) {
-	docker, err := config.GetString("docker:binary")
+	log.Printf("Getting ipaddress to instance %s", c.instanceId)
+	driver, err := currentDriver()
 	if err != nil {
 		return "", err
 	}
-	log.Printf("Getting ipaddress to instance %s", c.instanceId)
-	instanceJson, err := runCmd(docker, "inspect", c.instanceId)
+	result, err := driver.Inspect(c)
 	if err != nil {
 		msg := "error(%s) trying to inspect docker instance(%s) to get ipaddress"
 		log.Printf(msg, err)
 		return "", errors.New(msg)
 	}
-	var result map[string]interface{}
-	if err := json.Unmarshal([]byte(instanceJson), &result); err != nil {
-		msg := "error(%s) parsing json from docker when trying to get ipaddress"
-		log.Printf(msg, err)
-		return "", errors.New(msg)
-	}
 	if ns, ok := result["NetworkSettings"]; !ok || ns == nil {
 		msg := "Error when getting container information. NetworkSettings is missing."
 		log.Printf(msg)
@@ -73,78 +67,87 @@ func (c *container) ip() (string, error) {
 	return instanceIp, nil
 }
 
-// create creates a docker container with base template by default.
+// create creates a docker container, dispatching to the ContainerDriver
+// configured under docker:driver (the shell-based "docker" driver by
+// default).
 func (c *container) create() (string, error) {
-	docker, err := config.GetString("docker:binary")
+	driver, err := currentDriver()
 	if err != nil {
 		return "", err
 	}
-	template, err := config.GetString("docker:image")
-	if err != nil {
-		return "", err
+	return driver.Create(c)
+}
+
+// pulledImage tries to pull template from the configured registry and
+// returns its tagged name on success. If no registry is configured or the
+// pull fails, it returns template unchanged so callers fall back to the
+// base image. A digest mismatch under docker:registry:trust is not a
+// fallback case: it is returned as an error so create refuses to start
+// the container outright, instead of silently running an unverified one.
+func (c *container) pulledImage(template string) (string, error) {
+	addr, err := registry.Addr()
+	if err != nil || addr == "" {
+		return template, nil
 	}
-	cmd, err := config.GetString("docker:cmd:bin")
-	if err != nil {
-		return "", err
+	tagged := registry.Tagged(template)
+	if err := registry.Pull(tagged); err != nil {
+		log.Printf("could not pull image %s, falling back to base template: %s", tagged, err)
+		return template, nil
 	}
-	args, err := config.GetList("docker:cmd:args")
-	if err != nil {
+	if err := registry.CheckTrust(tagged); err != nil {
 		return "", err
 	}
-	args = append([]string{"run", "-d", template, cmd}, args...)
-	instanceId, err := runCmd(docker, args...)
-	instanceId = strings.Replace(instanceId, "\n", "", -1)
-	log.Printf("docker instanceId=%s", instanceId)
-	return instanceId, err
+	return tagged, nil
 }
 
-// start starts a docker container.
+// start starts a docker container, dispatching to the configured driver.
 func (c *container) start() error {
-	// it isn't necessary to start a docker container after docker run.
-	return nil
+	driver, err := currentDriver()
+	if err != nil {
+		return err
+	}
+	return driver.Start(c)
 }
 
-// stop stops a docker container.
+// stop stops a docker container, dispatching to the configured driver.
 func (c *container) stop() error {
-	docker, err := config.GetString("docker:binary")
+	driver, err := currentDriver()
 	if err != nil {
 		return err
 	}
-	//TODO: better error handling
-	log.Printf("trying to stop instance %s", c.instanceId)
-	output, err := runCmd(docker, "stop", c.instanceId)
-	log.Printf("docker stop=%s", output)
-	return err
+	return driver.Stop(c)
 }
 
-// destroy destory a docker container.
+// destroy destroys a docker container, dispatching to the configured
+// driver.
 func (c *container) destroy() error {
-	docker, err := config.GetString("docker:binary")
+	driver, err := currentDriver()
 	if err != nil {
 		return err
 	}
-	//TODO: better error handling
-	//TODO: Remove host's nginx route
-	log.Printf("trying to destroy instance %s", c.instanceId)
-	_, err = runCmd(docker, "rm", c.instanceId)
-	return err
+	return driver.Destroy(c)
 }
 
+// commit commits the container to imgName, dispatching to the configured
+// driver. The built-in "docker" and "docker-http" drivers also push the
+// resulting image when a registry is configured so it can be pulled by
+// other nodes in create; a plugin driver only forwards the commit to its
+// plugin and pushes nothing itself.
 func (c *container) commit(imgName string) error {
-	docker, err := config.GetString("docker:binary")
+	driver, err := currentDriver()
 	if err != nil {
 		return err
 	}
-	registryUser, err := config.GetString("docker:registry-user")
-	if err != nil {
-		return err
-	}
-	log.Printf("attempting to commit image from container %s", c.instanceId)
-	imgName = fmt.Sprintf("%s/%s", registryUser, imgName)
-	_, err = runCmd(docker, "commit", c.instanceId, imgName)
-	if err != nil {
-		log.Printf("Could not commit docker image: %s", err.Error())
-		return err
+	return driver.Commit(c, imgName)
+}
+
+// push pushes imgName to the configured registry, when one is set. It is
+// a no-op otherwise, so running without docker:registry keeps behaving
+// like before.
+func (c *container) push(imgName string) error {
+	addr, err := registry.Addr()
+	if err != nil || addr == "" {
+		return nil
 	}
-	return nil
+	return registry.Push(registry.Tagged(imgName))
 }
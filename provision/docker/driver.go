@@ -0,0 +1,75 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/globocom/config"
+)
+
+// ContainerDriver is implemented by anything able to manage the lifecycle
+// of a container on a node. Built-in drivers are "docker", a thin wrapper
+// around the docker CLI, and "docker-http", which talks to the Docker
+// Remote API directly. Out-of-process drivers can also be registered, see
+// InstallPlugin and loadPlugins.
+type ContainerDriver interface {
+	Create(c *container) (string, error)
+	Start(c *container) error
+	Stop(c *container) error
+	Destroy(c *container) error
+	Inspect(c *container) (map[string]interface{}, error)
+	Commit(c *container, imgName string) error
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]ContainerDriver)
+)
+
+// RegisterDriver registers a ContainerDriver under name, so it can later
+// be selected with docker:driver. Registering a name twice overwrites the
+// previous driver, the same way node-container managers are registered.
+func RegisterDriver(name string, driver ContainerDriver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = driver
+}
+
+// GetDriver returns the driver registered under name.
+func GetDriver(name string) (ContainerDriver, error) {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	driver, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown container driver: %q", name)
+	}
+	return driver, nil
+}
+
+// unregisterDriver removes name's driver from the registry, used by
+// disablePlugin and removePlugin.
+func unregisterDriver(name string) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	delete(drivers, name)
+}
+
+func init() {
+	RegisterDriver("docker", &shellDriver{})
+	RegisterDriver("docker-http", &httpDriver{})
+}
+
+// currentDriver returns the driver configured under docker:driver,
+// defaulting to the shell-based "docker" driver used historically by this
+// package.
+func currentDriver() (ContainerDriver, error) {
+	name, err := config.GetString("docker:driver")
+	if err != nil || name == "" {
+		name = "docker"
+	}
+	return GetDriver(name)
+}
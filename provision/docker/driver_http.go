@@ -0,0 +1,142 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/globocom/config"
+)
+
+// httpDriver talks to the Docker Remote API directly through
+// go-dockerclient, instead of shelling out to the docker binary. Unlike
+// shellDriver, Inspect returns structured data straight from the API, so
+// callers don't need to parse JSON out of a CLI command's stdout.
+type httpDriver struct{}
+
+func httpClient() (*docker.Client, error) {
+	endpoint, err := config.GetString("docker:http:endpoint")
+	if err != nil {
+		return nil, err
+	}
+	return docker.NewClient(endpoint)
+}
+
+func (httpDriver) Create(c *container) (string, error) {
+	client, err := httpClient()
+	if err != nil {
+		return "", err
+	}
+	template, err := config.GetString("docker:image")
+	if err != nil {
+		return "", err
+	}
+	cmd, err := config.GetString("docker:cmd:bin")
+	if err != nil {
+		return "", err
+	}
+	args, err := config.GetList("docker:cmd:args")
+	if err != nil {
+		return "", err
+	}
+	image, err := c.pulledImage(template)
+	if err != nil {
+		return "", err
+	}
+	cont, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image: image,
+			Cmd:   append([]string{cmd}, args...),
+		},
+		HostConfig: hostConfig(),
+	})
+	if err != nil {
+		return "", err
+	}
+	err = client.StartContainer(cont.ID, cont.HostConfig)
+	return cont.ID, err
+}
+
+// hostConfig builds the HostConfig shared by every container this driver
+// creates from docker:hostconfig:* settings, mirroring the HostPID,
+// HostIPC, ReadonlyRootfs, CapAdd/CapDrop and memory/memory-swap/cpu-shares
+// wiring used by the kubernetes provisioner's node containers.
+func hostConfig() *docker.HostConfig {
+	pidMode, _ := config.GetString("docker:hostconfig:pid-mode")
+	ipcMode, _ := config.GetString("docker:hostconfig:ipc-mode")
+	readonly, _ := config.GetBool("docker:hostconfig:readonly-rootfs")
+	capAdd, _ := config.GetList("docker:hostconfig:cap-add")
+	capDrop, _ := config.GetList("docker:hostconfig:cap-drop")
+	memory, _ := config.GetInt("docker:hostconfig:memory")
+	memorySwap, _ := config.GetInt("docker:hostconfig:memory-swap")
+	cpuShares, _ := config.GetInt("docker:hostconfig:cpu-shares")
+	return &docker.HostConfig{
+		PidMode:        pidMode,
+		IpcMode:        ipcMode,
+		ReadonlyRootfs: readonly,
+		CapAdd:         capAdd,
+		CapDrop:        capDrop,
+		Memory:         int64(memory),
+		MemorySwap:     int64(memorySwap),
+		CPUShares:      int64(cpuShares),
+	}
+}
+
+func (httpDriver) Start(c *container) error {
+	// Create already starts the container right after creating it.
+	return nil
+}
+
+func (httpDriver) Stop(c *container) error {
+	client, err := httpClient()
+	if err != nil {
+		return err
+	}
+	return client.StopContainer(c.instanceId, 10)
+}
+
+func (httpDriver) Destroy(c *container) error {
+	client, err := httpClient()
+	if err != nil {
+		return err
+	}
+	return client.RemoveContainer(docker.RemoveContainerOptions{ID: c.instanceId})
+}
+
+func (httpDriver) Commit(c *container, imgName string) error {
+	client, err := httpClient()
+	if err != nil {
+		return err
+	}
+	registryUser, err := config.GetString("docker:registry-user")
+	if err != nil {
+		return err
+	}
+	fullName := registryUser + "/" + imgName
+	_, err = client.CommitContainer(docker.CommitContainerOptions{
+		Container:  c.instanceId,
+		Repository: fullName,
+	})
+	if err != nil {
+		return err
+	}
+	return c.push(fullName)
+}
+
+func (httpDriver) Inspect(c *container) (map[string]interface{}, error) {
+	client, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
+	cont, err := client.InspectContainer(c.instanceId)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{
+		"NetworkSettings": map[string]interface{}{
+			"IpAddress": cont.NetworkSettings.IPAddress,
+		},
+	}
+	return result, nil
+}
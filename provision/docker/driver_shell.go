@@ -0,0 +1,158 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/log"
+)
+
+// shellDriver is the historical driver for this package: it shells out to
+// the docker binary configured in docker:binary.
+type shellDriver struct{}
+
+// Create creates a docker container. It tries to pull the base template by
+// repository+tag from the configured registry first, so a previously
+// pushed image can be reused on a different node, and only falls back to
+// running the plain base template when the pull fails.
+func (shellDriver) Create(c *container) (string, error) {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return "", err
+	}
+	template, err := config.GetString("docker:image")
+	if err != nil {
+		return "", err
+	}
+	cmd, err := config.GetString("docker:cmd:bin")
+	if err != nil {
+		return "", err
+	}
+	args, err := config.GetList("docker:cmd:args")
+	if err != nil {
+		return "", err
+	}
+	image, err := c.pulledImage(template)
+	if err != nil {
+		return "", err
+	}
+	runArgs := append([]string{"run", "-d"}, hostConfigArgs()...)
+	runArgs = append(runArgs, image, cmd)
+	args = append(runArgs, args...)
+	instanceId, err := runCmd(docker, args...)
+	instanceId = strings.Replace(instanceId, "\n", "", -1)
+	log.Printf("docker instanceId=%s", instanceId)
+	return instanceId, err
+}
+
+// hostConfigArgs builds the `docker run` flags equivalent to the HostConfig
+// httpDriver assembles from docker:hostconfig:* settings, so both drivers
+// apply the same host-PID, read-only rootfs, capabilities and
+// memory/memory-swap/cpu-shares controls.
+func hostConfigArgs() []string {
+	var args []string
+	if pidMode, _ := config.GetString("docker:hostconfig:pid-mode"); pidMode == "host" {
+		args = append(args, "--pid=host")
+	}
+	if ipcMode, _ := config.GetString("docker:hostconfig:ipc-mode"); ipcMode == "host" {
+		args = append(args, "--ipc=host")
+	}
+	if readonly, _ := config.GetBool("docker:hostconfig:readonly-rootfs"); readonly {
+		args = append(args, "--read-only")
+	}
+	if capAdd, _ := config.GetList("docker:hostconfig:cap-add"); len(capAdd) > 0 {
+		for _, cap := range capAdd {
+			args = append(args, "--cap-add="+cap)
+		}
+	}
+	if capDrop, _ := config.GetList("docker:hostconfig:cap-drop"); len(capDrop) > 0 {
+		for _, cap := range capDrop {
+			args = append(args, "--cap-drop="+cap)
+		}
+	}
+	if memory, _ := config.GetInt("docker:hostconfig:memory"); memory > 0 {
+		args = append(args, fmt.Sprintf("--memory=%d", memory))
+	}
+	if memorySwap, _ := config.GetInt("docker:hostconfig:memory-swap"); memorySwap != 0 {
+		args = append(args, fmt.Sprintf("--memory-swap=%d", memorySwap))
+	}
+	if cpuShares, _ := config.GetInt("docker:hostconfig:cpu-shares"); cpuShares > 0 {
+		args = append(args, fmt.Sprintf("--cpu-shares=%d", cpuShares))
+	}
+	return args
+}
+
+// Start is a no-op: it isn't necessary to start a docker container after
+// docker run.
+func (shellDriver) Start(c *container) error {
+	return nil
+}
+
+func (shellDriver) Stop(c *container) error {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return err
+	}
+	//TODO: better error handling
+	log.Printf("trying to stop instance %s", c.instanceId)
+	output, err := runCmd(docker, "stop", c.instanceId)
+	log.Printf("docker stop=%s", output)
+	return err
+}
+
+// Destroy destroys a docker container.
+func (shellDriver) Destroy(c *container) error {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return err
+	}
+	//TODO: better error handling
+	//TODO: Remove host's nginx route
+	log.Printf("trying to destroy instance %s", c.instanceId)
+	_, err = runCmd(docker, "rm", c.instanceId)
+	return err
+}
+
+// Commit commits the container to imgName and, when a registry is
+// configured, pushes the resulting image so it can be pulled by other
+// nodes in Create.
+func (shellDriver) Commit(c *container, imgName string) error {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return err
+	}
+	registryUser, err := config.GetString("docker:registry-user")
+	if err != nil {
+		return err
+	}
+	log.Printf("attempting to commit image from container %s", c.instanceId)
+	imgName = fmt.Sprintf("%s/%s", registryUser, imgName)
+	_, err = runCmd(docker, "commit", c.instanceId, imgName)
+	if err != nil {
+		log.Printf("Could not commit docker image: %s", err.Error())
+		return err
+	}
+	return c.push(imgName)
+}
+
+func (shellDriver) Inspect(c *container) (map[string]interface{}, error) {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return nil, err
+	}
+	instanceJson, err := runCmd(docker, "inspect", c.instanceId)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(instanceJson), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
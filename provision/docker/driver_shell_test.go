@@ -0,0 +1,64 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/globocom/config"
+)
+
+func resetHostConfig() {
+	config.Unset("docker:hostconfig:pid-mode")
+	config.Unset("docker:hostconfig:ipc-mode")
+	config.Unset("docker:hostconfig:readonly-rootfs")
+	config.Unset("docker:hostconfig:cap-add")
+	config.Unset("docker:hostconfig:cap-drop")
+	config.Unset("docker:hostconfig:memory")
+	config.Unset("docker:hostconfig:memory-swap")
+	config.Unset("docker:hostconfig:cpu-shares")
+}
+
+func TestHostConfigArgsEmpty(t *testing.T) {
+	defer resetHostConfig()
+	if args := hostConfigArgs(); len(args) != 0 {
+		t.Fatalf("expected no flags with no docker:hostconfig settings, got %v", args)
+	}
+}
+
+func TestHostConfigArgsAll(t *testing.T) {
+	defer resetHostConfig()
+	config.Set("docker:hostconfig:pid-mode", "host")
+	config.Set("docker:hostconfig:ipc-mode", "host")
+	config.Set("docker:hostconfig:readonly-rootfs", true)
+	config.Set("docker:hostconfig:cap-add", []string{"NET_ADMIN"})
+	config.Set("docker:hostconfig:cap-drop", []string{"MKNOD"})
+	config.Set("docker:hostconfig:memory", 1024)
+	config.Set("docker:hostconfig:memory-swap", 2048)
+	config.Set("docker:hostconfig:cpu-shares", 512)
+	expected := []string{
+		"--pid=host",
+		"--ipc=host",
+		"--read-only",
+		"--cap-add=NET_ADMIN",
+		"--cap-drop=MKNOD",
+		"--memory=1024",
+		"--memory-swap=2048",
+		"--cpu-shares=512",
+	}
+	if args := hostConfigArgs(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+}
+
+func TestHostConfigArgsMemoryWithoutSwap(t *testing.T) {
+	defer resetHostConfig()
+	config.Set("docker:hostconfig:memory", 1024)
+	expected := []string{"--memory=1024"}
+	if args := hostConfigArgs(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %v, got %v", expected, args)
+	}
+}
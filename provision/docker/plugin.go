@@ -0,0 +1,252 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/log"
+)
+
+// pluginManifest describes an out-of-process ContainerDriver, installed as
+// a JSON file under the tsuru/plugins directory. Such a driver speaks to
+// tsuru over the socket at Socket, announcing what it can do through
+// Capabilities (e.g. "create", "commit").
+type pluginManifest struct {
+	Name         string   `json:"name"`
+	Socket       string   `json:"socket"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// pluginDriver adapts a pluginManifest to ContainerDriver by forwarding
+// every call as a request over the manifest's unix socket.
+type pluginDriver struct {
+	manifest pluginManifest
+}
+
+// pluginRequest is the line-delimited JSON request sent over a plugin's
+// socket for every ContainerDriver call.
+type pluginRequest struct {
+	Action     string   `json:"action"`
+	InstanceId string   `json:"instance"`
+	Args       []string `json:"args,omitempty"`
+}
+
+// pluginResponse is the line-delimited JSON response read back from a
+// plugin's socket.
+type pluginResponse struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+const pluginDialTimeout = 10 * time.Second
+
+// call sends action (plus any extra args, e.g. the image name for commit)
+// to the plugin over its socket and waits for a single JSON response line.
+func (d *pluginDriver) call(action string, c *container, extra ...string) (string, error) {
+	conn, err := net.DialTimeout("unix", d.manifest.Socket, pluginDialTimeout)
+	if err != nil {
+		return "", fmt.Errorf("could not reach plugin %s: %s", d.manifest.Name, err)
+	}
+	defer conn.Close()
+	req := pluginRequest{Action: action, InstanceId: c.instanceId, Args: extra}
+	if err := json.NewEncoder(conn).Encode(&req); err != nil {
+		return "", fmt.Errorf("could not send request to plugin %s: %s", d.manifest.Name, err)
+	}
+	var resp pluginResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&resp); err != nil {
+		return "", fmt.Errorf("could not read response from plugin %s: %s", d.manifest.Name, err)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("plugin %s: %s", d.manifest.Name, resp.Error)
+	}
+	return resp.Output, nil
+}
+
+func (d *pluginDriver) Create(c *container) (string, error) {
+	return d.call("create", c)
+}
+
+func (d *pluginDriver) Start(c *container) error {
+	_, err := d.call("start", c)
+	return err
+}
+
+func (d *pluginDriver) Stop(c *container) error {
+	_, err := d.call("stop", c)
+	return err
+}
+
+func (d *pluginDriver) Destroy(c *container) error {
+	_, err := d.call("destroy", c)
+	return err
+}
+
+func (d *pluginDriver) Commit(c *container, imgName string) error {
+	_, err := d.call("commit", c, imgName)
+	return err
+}
+
+func (d *pluginDriver) Inspect(c *container) (map[string]interface{}, error) {
+	out, err := d.call("inspect", c)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// pluginsDir returns the directory where plugin manifests are installed,
+// configurable under docker:plugins-dir and defaulting to
+// /etc/tsuru/plugins.
+func pluginsDir() (string, error) {
+	dir, err := config.GetString("docker:plugins-dir")
+	if err != nil || dir == "" {
+		dir = "/etc/tsuru/plugins"
+	}
+	return dir, nil
+}
+
+func manifestPath(name string) (string, error) {
+	dir, err := pluginsDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, name+".json"), nil
+}
+
+// InstallPlugin reads a manifest from src and registers it as a
+// ContainerDriver under its own name. It is the entry point used by the
+// plugin-install admin command.
+func InstallPlugin(src string) error {
+	_, err := installPlugin(src)
+	return err
+}
+
+// EnablePlugin re-enables a previously installed plugin by name.
+func EnablePlugin(name string) error {
+	p, err := manifestPath(name)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	var manifest pluginManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	enablePlugin(&manifest)
+	return nil
+}
+
+// DisablePlugin is the entry point used by the plugin-disable admin
+// command.
+func DisablePlugin(name string) {
+	disablePlugin(name)
+}
+
+// RemovePlugin is the entry point used by the plugin-remove admin
+// command.
+func RemovePlugin(name string) error {
+	return removePlugin(name)
+}
+
+// installPlugin reads a manifest from src and registers it as a
+// ContainerDriver under its own name.
+func installPlugin(src string) (*pluginManifest, error) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	var manifest pluginManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest %s: %s", src, err)
+	}
+	dst, err := manifestPath(manifest.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		return nil, err
+	}
+	enablePlugin(&manifest)
+	return &manifest, nil
+}
+
+// enablePlugin registers manifest's driver, making it selectable through
+// docker:driver.
+func enablePlugin(manifest *pluginManifest) {
+	RegisterDriver(manifest.Name, &pluginDriver{manifest: *manifest})
+}
+
+// disablePlugin removes name's driver from the registry, without deleting
+// its manifest from disk.
+func disablePlugin(name string) {
+	unregisterDriver(name)
+}
+
+// removePlugin disables name's driver and deletes its manifest.
+func removePlugin(name string) error {
+	disablePlugin(name)
+	p, err := manifestPath(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// loadPlugins registers every manifest found under pluginsDir, called on
+// startup so previously installed plugins survive a restart.
+func loadPlugins() error {
+	dir, err := pluginsDir()
+	if err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		data, err := ioutil.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var manifest pluginManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("invalid plugin manifest %s: %s", entry.Name(), err)
+		}
+		enablePlugin(&manifest)
+	}
+	return nil
+}
+
+// init loads every previously installed plugin manifest on package
+// initialization, so plugins registered through InstallPlugin survive a
+// tsuru restart instead of silently falling back to the default driver.
+func init() {
+	if err := loadPlugins(); err != nil {
+		log.Printf("could not load docker plugins: %s", err)
+	}
+}
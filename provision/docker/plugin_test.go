@@ -0,0 +1,183 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/globocom/config"
+)
+
+func TestInstallPluginRegistersDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsuru-plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Set("docker:plugins-dir", dir)
+	defer config.Unset("docker:plugins-dir")
+
+	manifest := pluginManifest{Name: "myplugin", Socket: "/tmp/myplugin.sock", Capabilities: []string{"create"}}
+	data, err := json.Marshal(&manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "src.json")
+	if err := ioutil.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterDriver("myplugin")
+
+	if err := InstallPlugin(src); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := GetDriver("myplugin"); err != nil {
+		t.Fatalf("expected driver to be registered, got error: %s", err)
+	}
+	installed, err := ioutil.ReadFile(filepath.Join(dir, "myplugin.json"))
+	if err != nil {
+		t.Fatalf("expected manifest to be persisted under plugins-dir: %s", err)
+	}
+	var persisted pluginManifest
+	if err := json.Unmarshal(installed, &persisted); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(persisted, manifest) {
+		t.Fatalf("expected persisted manifest %+v, got %+v", manifest, persisted)
+	}
+}
+
+func TestInstallPluginInvalidManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsuru-plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.json")
+	if err := ioutil.WriteFile(src, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallPlugin(src); err == nil {
+		t.Fatal("expected an error for an invalid manifest, got nil")
+	}
+}
+
+func TestRemovePluginUnregistersAndDeletesManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsuru-plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Set("docker:plugins-dir", dir)
+	defer config.Unset("docker:plugins-dir")
+
+	manifest := pluginManifest{Name: "removeme"}
+	enablePlugin(&manifest)
+	p, err := manifestPath("removeme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemovePlugin("removeme"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := GetDriver("removeme"); err == nil {
+		t.Fatal("expected driver to be unregistered")
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("expected manifest to be removed, stat err: %v", err)
+	}
+}
+
+func TestLoadPluginsRegistersEveryManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsuru-plugins-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Set("docker:plugins-dir", dir)
+	defer config.Unset("docker:plugins-dir")
+	defer unregisterDriver("loaded")
+
+	manifest := pluginManifest{Name: "loaded", Socket: "/tmp/loaded.sock"}
+	data, _ := json.Marshal(&manifest)
+	if err := ioutil.WriteFile(filepath.Join(dir, "loaded.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadPlugins(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := GetDriver("loaded"); err != nil {
+		t.Fatalf("expected driver to be registered, got error: %s", err)
+	}
+}
+
+// fakePluginServer listens on a unix socket and answers every pluginRequest
+// with resp, so pluginDriver.call can be tested without a real plugin
+// binary on the other end.
+func fakePluginServer(t *testing.T, resp pluginResponse) string {
+	dir, err := ioutil.TempDir("", "tsuru-plugin-sock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		defer os.RemoveAll(dir)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req pluginRequest
+		if err := json.NewDecoder(conn).Decode(&req); err != nil {
+			return
+		}
+		json.NewEncoder(conn).Encode(&resp)
+	}()
+	return socket
+}
+
+func TestPluginDriverCallSuccess(t *testing.T) {
+	socket := fakePluginServer(t, pluginResponse{Output: "container-id"})
+	d := &pluginDriver{manifest: pluginManifest{Name: "myplugin", Socket: socket}}
+	out, err := d.call("create", &container{name: "app-web"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out != "container-id" {
+		t.Fatalf("expected output %q, got %q", "container-id", out)
+	}
+}
+
+func TestPluginDriverCallPluginError(t *testing.T) {
+	socket := fakePluginServer(t, pluginResponse{Error: "something went wrong"})
+	d := &pluginDriver{manifest: pluginManifest{Name: "myplugin", Socket: socket}}
+	_, err := d.call("create", &container{name: "app-web"})
+	if err == nil {
+		t.Fatal("expected an error when the plugin reports one, got nil")
+	}
+}
+
+func TestPluginDriverCallUnreachableSocket(t *testing.T) {
+	d := &pluginDriver{manifest: pluginManifest{Name: "myplugin", Socket: "/nonexistent/plugin.sock"}}
+	if _, err := d.call("create", &container{name: "app-web"}); err == nil {
+		t.Fatal("expected an error when the plugin socket can't be reached, got nil")
+	}
+}
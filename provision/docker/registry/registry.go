@@ -0,0 +1,211 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package registry pushes and pulls images to/from a docker registry, and
+// verifies pinned image digests before a container is started (a poor
+// man's content trust).
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/globocom/config"
+	"github.com/globocom/tsuru/log"
+)
+
+// Client is implemented by anything able to push, pull and inspect images
+// on a docker registry. It exists so tests can provide a mock instead of
+// shelling out to the docker binary.
+type Client interface {
+	Push(repo string) error
+	Pull(repo string) error
+	Digest(repo string) (string, error)
+}
+
+var clnt Client
+
+// client returns the configured Client, building the default execClient
+// (which shells out to the docker binary) on first use.
+func client() Client {
+	if clnt == nil {
+		clnt = execClient{}
+	}
+	return clnt
+}
+
+// SetClient overrides the default Client. Used by tests to plug in a mock.
+func SetClient(c Client) {
+	clnt = c
+}
+
+// execClient talks to the registry through the local docker binary.
+type execClient struct{}
+
+func runCmd(cmd string, args ...string) (string, error) {
+	out := bytes.Buffer{}
+	command := exec.Command(cmd, args...)
+	command.Stdout = &out
+	command.Stderr = &out
+	err := command.Run()
+	log.Printf("running the cmd: %s with the args: %s", cmd, args)
+	return out.String(), err
+}
+
+// globalArgs returns the docker CLI global flags needed to authenticate
+// against, and optionally verify the TLS certificate of, the configured
+// registry: docker:registry:auth-file points --config at a directory
+// holding a config.json with registry credentials, and
+// docker:registry:tls-ca pins the CA used to verify the registry's
+// certificate.
+func globalArgs() []string {
+	var args []string
+	if authFile, _ := config.GetString("docker:registry:auth-file"); authFile != "" {
+		args = append(args, "--config", authFile)
+	}
+	if tlsCA, _ := config.GetString("docker:registry:tls-ca"); tlsCA != "" {
+		args = append(args, "--tlscacert", tlsCA, "--tlsverify")
+	}
+	return args
+}
+
+func (execClient) Push(repo string) error {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return err
+	}
+	log.Printf("pushing image %s to registry", repo)
+	args := append(globalArgs(), "push", repo)
+	_, err = runCmd(docker, args...)
+	if err != nil {
+		msg := fmt.Sprintf("error(%s) trying to push image %s to registry", err, repo)
+		log.Printf(msg)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (execClient) Pull(repo string) error {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return err
+	}
+	log.Printf("pulling image %s from registry", repo)
+	args := append(globalArgs(), "pull", repo)
+	_, err = runCmd(docker, args...)
+	if err != nil {
+		msg := fmt.Sprintf("error(%s) trying to pull image %s from registry", err, repo)
+		log.Printf(msg)
+		return errors.New(msg)
+	}
+	return nil
+}
+
+func (execClient) Digest(repo string) (string, error) {
+	docker, err := config.GetString("docker:binary")
+	if err != nil {
+		return "", err
+	}
+	args := append(globalArgs(), "inspect", "--format={{json .Id}}", repo)
+	instanceJson, err := runCmd(docker, args...)
+	if err != nil {
+		msg := fmt.Sprintf("error(%s) trying to inspect image %s to get its digest", err, repo)
+		log.Printf(msg)
+		return "", errors.New(msg)
+	}
+	var digest string
+	if err := json.Unmarshal([]byte(instanceJson), &digest); err != nil {
+		msg := fmt.Sprintf("error(%s) parsing digest for image %s", err, repo)
+		log.Printf(msg)
+		return "", errors.New(msg)
+	}
+	return digest, nil
+}
+
+// Addr returns the configured registry address, e.g. "registry.example.com:5000".
+func Addr() (string, error) {
+	return config.GetString("docker:registry")
+}
+
+// Tagged returns repo prefixed with the registry address, when one is
+// configured, otherwise it returns repo unchanged.
+func Tagged(repo string) string {
+	addr, err := Addr()
+	if err != nil || addr == "" {
+		return repo
+	}
+	return fmt.Sprintf("%s/%s", addr, repo)
+}
+
+// Push pushes repo to the configured registry.
+func Push(repo string) error {
+	return client().Push(repo)
+}
+
+// Pull pulls repo from the configured registry.
+func Pull(repo string) error {
+	return client().Pull(repo)
+}
+
+// TrustEnabled returns whether docker:registry:trust is set, enabling
+// digest verification against the pinned list configured under
+// docker:registry:pins (repo -> expected sha256).
+func TrustEnabled() bool {
+	enabled, _ := config.GetBool("docker:registry:trust")
+	return enabled
+}
+
+// pinnedDigests reads docker:registry:pins, returning an empty map when the
+// key is simply unset. Any other error (a malformed value, for instance)
+// is propagated instead of being treated as "no pins configured", since
+// silently disabling every pin would let CheckTrust wave through images it
+// was explicitly configured to verify.
+func pinnedDigests() (map[string]string, error) {
+	pins, err := config.Get("docker:registry:pins")
+	if err != nil {
+		if _, ok := err.(config.ErrKeyNotFound); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	raw, err := json.Marshal(pins)
+	if err != nil {
+		return nil, err
+	}
+	digests := map[string]string{}
+	if err := json.Unmarshal(raw, &digests); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// CheckTrust verifies that repo's digest matches its pinned value, when
+// trust is enabled and a pin exists for repo. It refuses to proceed
+// (returns an error) on any mismatch.
+func CheckTrust(repo string) error {
+	if !TrustEnabled() {
+		return nil
+	}
+	pins, err := pinnedDigests()
+	if err != nil {
+		return err
+	}
+	expected, ok := pins[repo]
+	if !ok {
+		return nil
+	}
+	got, err := client().Digest(repo)
+	if err != nil {
+		return err
+	}
+	if got != expected {
+		msg := fmt.Sprintf("refusing to start container: digest mismatch for %s, expected %s, got %s", repo, expected, got)
+		log.Printf(msg)
+		return errors.New(msg)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2013 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package registry
+
+import (
+	"testing"
+
+	"github.com/globocom/config"
+)
+
+type fakeClient struct {
+	digest    string
+	digestErr error
+}
+
+func (f *fakeClient) Push(repo string) error { return nil }
+func (f *fakeClient) Pull(repo string) error { return nil }
+func (f *fakeClient) Digest(repo string) (string, error) {
+	return f.digest, f.digestErr
+}
+
+func resetConfig() {
+	config.Unset("docker:registry:trust")
+	config.Unset("docker:registry:pins")
+}
+
+func TestCheckTrustDisabled(t *testing.T) {
+	defer resetConfig()
+	SetClient(&fakeClient{digest: "sha256:deadbeef"})
+	if err := CheckTrust("tsuru/python"); err != nil {
+		t.Fatalf("expected no error with trust disabled, got %s", err)
+	}
+}
+
+func TestCheckTrustNoPinForRepo(t *testing.T) {
+	defer resetConfig()
+	config.Set("docker:registry:trust", true)
+	config.Set("docker:registry:pins", map[string]interface{}{})
+	SetClient(&fakeClient{digest: "sha256:deadbeef"})
+	if err := CheckTrust("tsuru/python"); err != nil {
+		t.Fatalf("expected no error when repo has no pin, got %s", err)
+	}
+}
+
+func TestCheckTrustMatchingDigest(t *testing.T) {
+	defer resetConfig()
+	config.Set("docker:registry:trust", true)
+	config.Set("docker:registry:pins", map[string]interface{}{
+		"tsuru/python": "sha256:deadbeef",
+	})
+	SetClient(&fakeClient{digest: "sha256:deadbeef"})
+	if err := CheckTrust("tsuru/python"); err != nil {
+		t.Fatalf("expected no error on matching digest, got %s", err)
+	}
+}
+
+func TestCheckTrustMismatchedDigestRefuses(t *testing.T) {
+	defer resetConfig()
+	config.Set("docker:registry:trust", true)
+	config.Set("docker:registry:pins", map[string]interface{}{
+		"tsuru/python": "sha256:deadbeef",
+	})
+	SetClient(&fakeClient{digest: "sha256:tampered"})
+	err := CheckTrust("tsuru/python")
+	if err == nil {
+		t.Fatal("expected an error on digest mismatch, got nil")
+	}
+}
+
+func TestCheckTrustNoPinsConfigured(t *testing.T) {
+	defer resetConfig()
+	config.Set("docker:registry:trust", true)
+	SetClient(&fakeClient{digest: "sha256:deadbeef"})
+	if err := CheckTrust("tsuru/python"); err != nil {
+		t.Fatalf("expected no error when docker:registry:pins is unset, got %s", err)
+	}
+}
+
+func TestCheckTrustPropagatesMalformedPins(t *testing.T) {
+	defer resetConfig()
+	config.Set("docker:registry:trust", true)
+	config.Set("docker:registry:pins", "not-a-map")
+	SetClient(&fakeClient{digest: "sha256:deadbeef"})
+	err := CheckTrust("tsuru/python")
+	if err == nil {
+		t.Fatal("expected an error when docker:registry:pins is malformed, got nil")
+	}
+}
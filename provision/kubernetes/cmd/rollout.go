@@ -0,0 +1,103 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cmd provides the tsuru client commands used to inspect and
+// control node-container DaemonSet rollouts, mirroring `kubectl rollout`.
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/tsuru/gnuflag"
+	"github.com/tsuru/tsuru/cmd"
+)
+
+type NodeContainerRolloutStatus struct {
+	fs   *gnuflag.FlagSet
+	pool string
+}
+
+func (c *NodeContainerRolloutStatus) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "node-container-rollout-status",
+		Usage:   "node-container rollout status <name> -p/--pool <pool>",
+		Desc:    "Shows the rollout status of a node-container's DaemonSet, including the last rollback target if one was recorded.",
+		MinArgs: 1,
+	}
+}
+
+func (c *NodeContainerRolloutStatus) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("node-container-rollout-status", gnuflag.ExitOnError)
+		poolMessage := "the pool whose DaemonSet should be inspected"
+		c.fs.StringVar(&c.pool, "pool", "", poolMessage)
+		c.fs.StringVar(&c.pool, "p", "", poolMessage)
+	}
+	return c.fs
+}
+
+func (c *NodeContainerRolloutStatus) Run(context *cmd.Context, client *cmd.Client) error {
+	name := context.Args[0]
+	u, err := cmd.GetURL(fmt.Sprintf("/docker/nodecontainers/%s/rollout", name))
+	if err != nil {
+		return err
+	}
+	u += "?" + url.Values{"pool": {c.pool}}.Encode()
+	request, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return cmd.StreamJSONResponse(context.Stdout, response)
+}
+
+type NodeContainerRolloutUndo struct {
+	fs   *gnuflag.FlagSet
+	pool string
+}
+
+func (c *NodeContainerRolloutUndo) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "node-container-rollout-undo",
+		Usage:   "node-container rollout undo <name> -p/--pool <pool>",
+		Desc:    "Rolls a node-container's DaemonSet back to the PodSpec it ran before its last update.",
+		MinArgs: 1,
+	}
+}
+
+func (c *NodeContainerRolloutUndo) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("node-container-rollout-undo", gnuflag.ExitOnError)
+		poolMessage := "the pool whose DaemonSet should be rolled back"
+		c.fs.StringVar(&c.pool, "pool", "", poolMessage)
+		c.fs.StringVar(&c.pool, "p", "", poolMessage)
+	}
+	return c.fs
+}
+
+func (c *NodeContainerRolloutUndo) Run(context *cmd.Context, client *cmd.Client) error {
+	name := context.Args[0]
+	u, err := cmd.GetURL(fmt.Sprintf("/docker/nodecontainers/%s/rollout/undo", name))
+	if err != nil {
+		return err
+	}
+	u += "?" + url.Values{"pool": {c.pool}}.Encode()
+	request, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	fmt.Fprintf(context.Stdout, "rollback requested for node-container %s\n", name)
+	return nil
+}
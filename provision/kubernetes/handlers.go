@@ -0,0 +1,84 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/api"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RolloutStatusInfo is the status returned by GET
+// /docker/nodecontainers/{name}/rollout, backing the
+// node-container-rollout-status client command.
+type RolloutStatusInfo struct {
+	Desired           int32 `json:"desired"`
+	Ready             int32 `json:"ready"`
+	Unavailable       int32 `json:"unavailable"`
+	RollbackAvailable bool  `json:"rollbackAvailable"`
+}
+
+// RolloutStatus reports the rollout status of the DaemonSet backing the
+// node container name on pool.
+func RolloutStatus(pool, name string) (*RolloutStatusInfo, error) {
+	dsName := daemonSetName(name, pool)
+	var status *RolloutStatusInfo
+	err := forEachCluster(func(client *clusterClient) error {
+		ds, err := client.Extensions().DaemonSets(client.Namespace()).Get(dsName, metav1.GetOptions{})
+		if err != nil {
+			if k8sErrors.IsNotFound(err) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		_, rollbackAvailable := ds.Spec.Template.ObjectMeta.Annotations[previousPodSpecAnnotation]
+		status = &RolloutStatusInfo{
+			Desired:           ds.Status.DesiredNumberScheduled,
+			Ready:             ds.Status.NumberReady,
+			Unavailable:       ds.Status.NumberUnavailable,
+			RollbackAvailable: rollbackAvailable,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: "node container DaemonSet not found"}
+	}
+	return status, nil
+}
+
+// RolloutUndo rolls the DaemonSet backing the node container name on pool
+// back to the PodSpec it ran before its last update.
+func RolloutUndo(pool, name string) error {
+	dsName := daemonSetName(name, pool)
+	m := nodeContainerManager{}
+	return forEachCluster(func(client *clusterClient) error {
+		return m.rollbackDaemonSet(client, dsName)
+	})
+}
+
+func rolloutStatusHandler(w http.ResponseWriter, r *http.Request) error {
+	status, err := RolloutStatus(r.URL.Query().Get("pool"), r.URL.Query().Get(":name"))
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(status)
+}
+
+func rolloutUndoHandler(w http.ResponseWriter, r *http.Request) error {
+	return RolloutUndo(r.URL.Query().Get("pool"), r.URL.Query().Get(":name"))
+}
+
+func init() {
+	api.RegisterHandler("/docker/nodecontainers/{name}/rollout", "GET", api.AuthorizationRequiredHandler(rolloutStatusHandler))
+	api.RegisterHandler("/docker/nodecontainers/{name}/rollout/undo", "POST", api.AuthorizationRequiredHandler(rolloutUndoHandler))
+}
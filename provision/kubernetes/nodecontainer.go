@@ -9,7 +9,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
@@ -18,13 +20,25 @@ import (
 	"github.com/tsuru/tsuru/provision/nodecontainer"
 	"github.com/tsuru/tsuru/provision/servicecommon"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/pkg/api/v1"
 	extensions "k8s.io/client-go/pkg/apis/extensions/v1beta1"
 )
 
-const alphaAffinityAnnotation = "scheduler.alpha.kubernetes.io/affinity"
+const (
+	alphaAffinityAnnotation = "scheduler.alpha.kubernetes.io/affinity"
+
+	// previousPodSpecAnnotation stores the PodSpec that was live right
+	// before a rollout, so a failed rollout can be rolled back to it.
+	previousPodSpecAnnotation = "tsuru.io/previous-podspec"
+
+	defaultMaxUnavailable   = "20%"
+	defaultRolloutDeadline  = 5 * time.Minute
+	defaultRolloutPoll      = 2 * time.Second
+	defaultUnavailableLimit = 1
+)
 
 type nodeContainerManager struct{}
 
@@ -132,13 +146,32 @@ func (m *nodeContainerManager) deployNodeContainerForCluster(client *clusterClie
 		volumes = append(volumes, vol)
 		volumeMounts = append(volumeMounts, mount)
 	}
+	if config.HostConfig.ReadonlyRootfs {
+		for _, mount := range volumeMounts {
+			if !mount.ReadOnly {
+				return errors.Errorf("node container %s: ReadonlyRootfs can't be combined with writable non-volume paths, mount %s is writable", config.Name, mount.MountPath)
+			}
+		}
+	}
 	var secCtx *v1.SecurityContext
-	if config.HostConfig.Privileged {
-		trueVar := true
-		secCtx = &v1.SecurityContext{
-			Privileged: &trueVar,
+	if config.HostConfig.Privileged || config.HostConfig.ReadonlyRootfs || len(config.HostConfig.CapAdd) > 0 || len(config.HostConfig.CapDrop) > 0 {
+		secCtx = &v1.SecurityContext{}
+		if config.HostConfig.Privileged {
+			trueVar := true
+			secCtx.Privileged = &trueVar
+		}
+		if config.HostConfig.ReadonlyRootfs {
+			trueVar := true
+			secCtx.ReadOnlyRootFilesystem = &trueVar
+		}
+		if len(config.HostConfig.CapAdd) > 0 || len(config.HostConfig.CapDrop) > 0 {
+			secCtx.Capabilities = &v1.Capabilities{
+				Add:  stringsToCapabilities(config.HostConfig.CapAdd),
+				Drop: stringsToCapabilities(config.HostConfig.CapDrop),
+			}
 		}
 	}
+	resources := resourceRequirements(config.HostConfig)
 	restartPolicy := v1.RestartPolicyAlways
 	switch config.HostConfig.RestartPolicy.Name {
 	case docker.RestartOnFailure(0).Name:
@@ -146,7 +179,20 @@ func (m *nodeContainerManager) deployNodeContainerForCluster(client *clusterClie
 	case docker.NeverRestart().Name:
 		restartPolicy = v1.RestartPolicyNever
 	}
-	maxUnavailable := intstr.FromString("20%")
+	updateStrategy := extensions.DaemonSetUpdateStrategy{Type: extensions.RollingUpdateDaemonSetStrategyType}
+	if config.UpdateStrategy == "OnDelete" {
+		updateStrategy.Type = extensions.OnDeleteDaemonSetStrategyType
+	} else {
+		maxUnavailable := maxUnavailableValue(config.MaxUnavailable)
+		updateStrategy.RollingUpdate = &extensions.RollingUpdateDaemonSet{
+			MaxUnavailable: &maxUnavailable,
+		}
+	}
+	if oldDs != nil {
+		if prevSpec, marshalErr := json.Marshal(oldDs.Spec.Template.Spec); marshalErr == nil {
+			affinityAnnotation[previousPodSpecAnnotation] = string(prevSpec)
+		}
+	}
 	ds := &extensions.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      dsName,
@@ -156,12 +202,8 @@ func (m *nodeContainerManager) deployNodeContainerForCluster(client *clusterClie
 			Selector: &metav1.LabelSelector{
 				MatchLabels: ls.ToNodeContainerSelector(),
 			},
-			UpdateStrategy: extensions.DaemonSetUpdateStrategy{
-				Type: extensions.RollingUpdateDaemonSetStrategyType,
-				RollingUpdate: &extensions.RollingUpdateDaemonSet{
-					MaxUnavailable: &maxUnavailable,
-				},
-			},
+			UpdateStrategy:  updateStrategy,
+			MinReadySeconds: int32(config.MinReadySeconds),
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels:      ls.ToLabels(),
@@ -172,6 +214,8 @@ func (m *nodeContainerManager) deployNodeContainerForCluster(client *clusterClie
 					Volumes:       volumes,
 					RestartPolicy: restartPolicy,
 					HostNetwork:   config.HostConfig.NetworkMode == "host",
+					HostPID:       config.HostConfig.PidMode == "host",
+					HostIPC:       config.HostConfig.IpcMode == "host",
 					Containers: []v1.Container{
 						{
 							Name:            config.Name,
@@ -183,6 +227,9 @@ func (m *nodeContainerManager) deployNodeContainerForCluster(client *clusterClie
 							TTY:             config.Config.Tty,
 							VolumeMounts:    volumeMounts,
 							SecurityContext: secCtx,
+							Resources:       resources,
+							ReadinessProbe:  buildProbe(config.ReadinessProbe),
+							LivenessProbe:   buildProbe(config.LivenessProbe),
 						},
 					},
 				},
@@ -194,6 +241,137 @@ func (m *nodeContainerManager) deployNodeContainerForCluster(client *clusterClie
 	} else {
 		_, err = client.Extensions().DaemonSets(client.Namespace()).Create(ds)
 	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if oldDs != nil && (config.ReadinessProbe != nil || config.LivenessProbe != nil) {
+		return m.watchRollout(client, dsName)
+	}
+	return nil
+}
+
+// maxUnavailableValue parses a config.MaxUnavailable value, which may be a
+// bare integer ("3") or a percentage ("20%"), into the IntOrString form the
+// DaemonSet API expects. An empty value falls back to 20%, matching the
+// previous hard-coded behavior.
+func maxUnavailableValue(raw string) intstr.IntOrString {
+	if raw == "" {
+		raw = defaultMaxUnavailable
+	}
+	if n, err := strconv.Atoi(raw); err == nil {
+		return intstr.FromInt(n)
+	}
+	return intstr.FromString(raw)
+}
+
+// stringsToCapabilities converts a HostConfig CapAdd/CapDrop list into the
+// v1.Capability slice the pod SecurityContext expects.
+func stringsToCapabilities(caps []string) []v1.Capability {
+	if len(caps) == 0 {
+		return nil
+	}
+	result := make([]v1.Capability, len(caps))
+	for i, c := range caps {
+		result[i] = v1.Capability(c)
+	}
+	return result
+}
+
+// resourceRequirements translates HostConfig's memory, memory-swap and
+// cpu-shares trio into the closest equivalent v1.ResourceRequirements.
+// Kubernetes has no separate swap limit, so memory-swap only widens the
+// memory limit when it is larger than memory itself (docker's "-1"
+// unlimited swap has no direct equivalent either, and is simply left out
+// of the result). cpu-shares is converted assuming docker's baseline of
+// 1024 shares per CPU.
+func resourceRequirements(hostConfig docker.HostConfig) v1.ResourceRequirements {
+	limits := v1.ResourceList{}
+	requests := v1.ResourceList{}
+	memory := hostConfig.Memory
+	if hostConfig.MemorySwap > memory {
+		memory = hostConfig.MemorySwap
+	}
+	if memory > 0 {
+		limits[v1.ResourceMemory] = *resource.NewQuantity(memory, resource.BinarySI)
+	}
+	if hostConfig.CPUShares > 0 {
+		milliCPU := hostConfig.CPUShares * 1000 / 1024
+		requests[v1.ResourceCPU] = *resource.NewMilliQuantity(milliCPU, resource.DecimalSI)
+	}
+	var result v1.ResourceRequirements
+	if len(limits) > 0 {
+		result.Limits = limits
+	}
+	if len(requests) > 0 {
+		result.Requests = requests
+	}
+	return result
+}
+
+// buildProbe converts a nodecontainer probe config into the equivalent
+// v1.Probe, returning nil when cfg is nil so no probe is attached.
+func buildProbe(cfg *nodecontainer.ProbeConfig) *v1.Probe {
+	if cfg == nil {
+		return nil
+	}
+	probe := &v1.Probe{
+		TimeoutSeconds:      int32(cfg.TimeoutSeconds),
+		PeriodSeconds:       int32(cfg.PeriodSeconds),
+		FailureThreshold:    int32(cfg.FailureThreshold),
+		InitialDelaySeconds: int32(cfg.InitialDelaySeconds),
+	}
+	switch {
+	case len(cfg.Exec) > 0:
+		probe.Exec = &v1.ExecAction{Command: cfg.Exec}
+	case cfg.HTTPPath != "":
+		probe.HTTPGet = &v1.HTTPGetAction{
+			Path: cfg.HTTPPath,
+			Port: intstr.FromInt(cfg.Port),
+		}
+	case cfg.Port != 0:
+		probe.TCPSocket = &v1.TCPSocketAction{Port: intstr.FromInt(cfg.Port)}
+	default:
+		return nil
+	}
+	return probe
+}
+
+// watchRollout polls the DaemonSet status after an update and, if the
+// number of unavailable pods stays above defaultUnavailableLimit until
+// defaultRolloutDeadline elapses, rolls back to the PodSpec cached in
+// previousPodSpecAnnotation.
+func (m *nodeContainerManager) watchRollout(client *clusterClient, dsName string) error {
+	deadline := time.Now().Add(defaultRolloutDeadline)
+	for time.Now().Before(deadline) {
+		time.Sleep(defaultRolloutPoll)
+		ds, err := client.Extensions().DaemonSets(client.Namespace()).Get(dsName, metav1.GetOptions{})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if ds.Status.NumberUnavailable <= defaultUnavailableLimit {
+			return nil
+		}
+	}
+	return m.rollbackDaemonSet(client, dsName)
+}
+
+// rollbackDaemonSet restores the PodSpec cached in previousPodSpecAnnotation
+// on dsName, undoing a rollout that failed to become healthy.
+func (m *nodeContainerManager) rollbackDaemonSet(client *clusterClient, dsName string) error {
+	ds, err := client.Extensions().DaemonSets(client.Namespace()).Get(dsName, metav1.GetOptions{})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	prevSpec, ok := ds.Spec.Template.ObjectMeta.Annotations[previousPodSpecAnnotation]
+	if !ok {
+		return errors.Errorf("no previous PodSpec recorded for %s, cannot roll back", dsName)
+	}
+	var spec v1.PodSpec
+	if err := json.Unmarshal([]byte(prevSpec), &spec); err != nil {
+		return errors.WithStack(err)
+	}
+	ds.Spec.Template.Spec = spec
+	_, err = client.Extensions().DaemonSets(client.Namespace()).Update(ds)
 	return errors.WithStack(err)
 }
 
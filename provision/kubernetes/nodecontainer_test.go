@@ -0,0 +1,116 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubernetes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/provision/nodecontainer"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestMaxUnavailableValueDefault(t *testing.T) {
+	if v := maxUnavailableValue(""); v != intstr.FromString(defaultMaxUnavailable) {
+		t.Fatalf("expected default %s, got %+v", defaultMaxUnavailable, v)
+	}
+}
+
+func TestMaxUnavailableValueInt(t *testing.T) {
+	if v := maxUnavailableValue("3"); v != intstr.FromInt(3) {
+		t.Fatalf("expected 3, got %+v", v)
+	}
+}
+
+func TestMaxUnavailableValuePercentage(t *testing.T) {
+	if v := maxUnavailableValue("50%"); v != intstr.FromString("50%") {
+		t.Fatalf("expected 50%%, got %+v", v)
+	}
+}
+
+func TestStringsToCapabilitiesEmpty(t *testing.T) {
+	if caps := stringsToCapabilities(nil); caps != nil {
+		t.Fatalf("expected nil, got %v", caps)
+	}
+}
+
+func TestStringsToCapabilities(t *testing.T) {
+	caps := stringsToCapabilities([]string{"NET_ADMIN", "SYS_TIME"})
+	expected := []v1.Capability{"NET_ADMIN", "SYS_TIME"}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Fatalf("expected %v, got %v", expected, caps)
+	}
+}
+
+func TestResourceRequirementsEmpty(t *testing.T) {
+	r := resourceRequirements(docker.HostConfig{})
+	if r.Limits != nil || r.Requests != nil {
+		t.Fatalf("expected no limits or requests, got %+v", r)
+	}
+}
+
+func TestResourceRequirementsMemory(t *testing.T) {
+	r := resourceRequirements(docker.HostConfig{Memory: 1024})
+	if got := r.Limits[v1.ResourceMemory].Value(); got != 1024 {
+		t.Fatalf("expected memory limit 1024, got %d", got)
+	}
+}
+
+func TestResourceRequirementsMemorySwapWidensLimit(t *testing.T) {
+	r := resourceRequirements(docker.HostConfig{Memory: 1024, MemorySwap: 2048})
+	if got := r.Limits[v1.ResourceMemory].Value(); got != 2048 {
+		t.Fatalf("expected swap to widen the memory limit to 2048, got %d", got)
+	}
+}
+
+func TestResourceRequirementsMemorySwapSmallerThanMemory(t *testing.T) {
+	r := resourceRequirements(docker.HostConfig{Memory: 2048, MemorySwap: 1024})
+	if got := r.Limits[v1.ResourceMemory].Value(); got != 2048 {
+		t.Fatalf("expected memory limit to stay 2048, got %d", got)
+	}
+}
+
+func TestResourceRequirementsCPUShares(t *testing.T) {
+	r := resourceRequirements(docker.HostConfig{CPUShares: 512})
+	got := r.Requests[v1.ResourceCPU]
+	if got.MilliValue() != 500 {
+		t.Fatalf("expected 500 milliCPU for 512 shares, got %d", got.MilliValue())
+	}
+}
+
+func TestBuildProbeNil(t *testing.T) {
+	if p := buildProbe(nil); p != nil {
+		t.Fatalf("expected nil probe, got %+v", p)
+	}
+}
+
+func TestBuildProbeEmptyConfig(t *testing.T) {
+	if p := buildProbe(&nodecontainer.ProbeConfig{}); p != nil {
+		t.Fatalf("expected nil probe when no action is configured, got %+v", p)
+	}
+}
+
+func TestBuildProbeExec(t *testing.T) {
+	p := buildProbe(&nodecontainer.ProbeConfig{Exec: []string{"cat", "/healthy"}})
+	if p == nil || p.Exec == nil || !reflect.DeepEqual(p.Exec.Command, []string{"cat", "/healthy"}) {
+		t.Fatalf("expected an exec probe, got %+v", p)
+	}
+}
+
+func TestBuildProbeHTTP(t *testing.T) {
+	p := buildProbe(&nodecontainer.ProbeConfig{HTTPPath: "/healthz", Port: 8080})
+	if p == nil || p.HTTPGet == nil || p.HTTPGet.Path != "/healthz" || p.HTTPGet.Port != intstr.FromInt(8080) {
+		t.Fatalf("expected an http probe, got %+v", p)
+	}
+}
+
+func TestBuildProbeTCP(t *testing.T) {
+	p := buildProbe(&nodecontainer.ProbeConfig{Port: 8080})
+	if p == nil || p.TCPSocket == nil || p.TCPSocket.Port != intstr.FromInt(8080) {
+		t.Fatalf("expected a tcp probe, got %+v", p)
+	}
+}
@@ -2,9 +2,27 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Package swarm provisions apps as swarm services through deployPipeline.
+//
+// Known gap: provision/kubernetes translates a NodeContainerConfig's
+// HostConfig (PidMode, IpcMode, ReadonlyRootfs, CapAdd, CapDrop, Memory,
+// MemorySwap, CPUShares) into the pods it creates, and provision/docker
+// applies the same controls from its docker:hostconfig:* settings to every
+// container it creates. This package has no equivalent wiring: it has no node
+// container manager and no file that builds a swarm ContainerSpec at all
+// (deploy and removeService, called from actions.go, live outside this
+// trimmed tree), so there is nothing to plug that translation into yet.
+// Node containers run through the swarm provisioner currently get none of
+// those controls.
 package swarm
 
 import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sort"
 
 	"github.com/fsouza/go-dockerclient"
@@ -13,6 +31,7 @@ import (
 	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/buildah"
 	"github.com/tsuru/tsuru/set"
 )
 
@@ -23,6 +42,18 @@ type pipelineArgs struct {
 	newImgData     *image.ImageMetadata
 	currentImage   string
 	currentImgData *image.ImageMetadata
+	// cacheFrom lists prior app images, pulled from the configured
+	// registry, whose layers the build step may reuse as a cache even
+	// when there's no local parent chain for newImage.
+	cacheFrom []string
+	// squash, when set, asks the build step to flatten newImage's
+	// layers into a single layer atop its FROM image once the build
+	// succeeds.
+	squash bool
+	// unsquashedImage is the pre-squash tag kept around by the build
+	// step so pushSquashedImage can fall back to it if pushing the
+	// squashed image fails.
+	unsquashedImage string
 }
 
 func rollbackAddedProcesses(args *pipelineArgs, processes []string) {
@@ -39,6 +70,217 @@ func rollbackAddedProcesses(args *pipelineArgs, processes []string) {
 	}
 }
 
+// pullCacheFrom pulls every image listed in args.cacheFrom, so the next
+// build can reuse their layers even when there's no local parent chain for
+// newImage. A pull failure for one cache image is logged and skipped
+// instead of failing the deploy, since a missing cache source only makes
+// the build slower, not incorrect.
+var pullCacheFrom = &action.Action{
+	Name: "pull-cache-from",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(*pipelineArgs)
+		for _, img := range args.cacheFrom {
+			err := args.client.PullImage(docker.PullImageOptions{Repository: img}, docker.AuthConfiguration{})
+			if err != nil {
+				log.Errorf("ignored error pulling cache-from image %s: %+v", img, err)
+			}
+		}
+		return ctx.Previous, nil
+	},
+}
+
+// buildahCommit replaces the docker commit + docker push pair with
+// buildah's own commit+push whenever build:backend=buildah: it builds
+// args.newImage on top of args.currentImage through a buildah.Builder
+// instead of a docker daemon, so this pipeline is what actually reaches
+// provision/buildah's From/Commit rather than leaving that package
+// unused, then pushes the result so other swarm nodes can pull it
+// (squashImage and pushSquashedImage both no-op when this ran).
+var buildahCommit = &action.Action{
+	Name: "buildah-commit",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(*pipelineArgs)
+		if !buildah.Enabled() {
+			return ctx.Previous, nil
+		}
+		builder, err := buildah.New()
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		if err := builder.From(args.currentImage); err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		if err := applyImageDiff(args.client, builder, args.newImage); err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		if err := builder.Commit(args.newImage); err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		if err := buildah.Push(args.newImage); err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		return ctx.Previous, nil
+	},
+}
+
+// applyImageDiff copies newImage's filesystem, as already built by docker,
+// on top of the working container builder has From'd, via builder.Copy, so
+// Commit bakes in the code docker just built instead of snapshotting an
+// untouched overlay. It exports newImage's filesystem through a throwaway
+// container, extracts the resulting tar into a scratch directory and
+// copies each regular file into builder one by one, since Copy only takes
+// one host path at a time.
+func applyImageDiff(client *docker.Client, builder *buildah.Builder, newImage string) error {
+	cont, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: newImage},
+	})
+	if err != nil {
+		return err
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID})
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(client.ExportContainer(docker.ExportContainerOptions{
+			ID:           cont.ID,
+			OutputStream: writer,
+		}))
+	}()
+	scratch, err := ioutil.TempDir("", "buildah-diff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		extracted := filepath.Join(scratch, hdr.Name)
+		if err := os.MkdirAll(filepath.Dir(extracted), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(extracted, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+		if err := builder.Copy(extracted, "/"+hdr.Name); err != nil {
+			return err
+		}
+	}
+}
+
+// squashImage flattens args.newImage's layers into a single layer when
+// args.squash is set, by exporting the built image's filesystem and
+// re-importing it as one layer. ImportImage has no way to carry over the
+// source image's Config (CMD/ENTRYPOINT/ENV/WORKDIR/exposed ports all come
+// back empty), so the squashed image is re-committed once more, with no
+// filesystem changes, purely to stamp the original Config back onto it via
+// CommitContainer's Run option. The pre-squash image is kept around as
+// args.unsquashedImage so pushSquashedImage can fall back to it.
+var squashImage = &action.Action{
+	Name: "squash-image",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(*pipelineArgs)
+		if !args.squash || buildah.Enabled() {
+			return ctx.Previous, nil
+		}
+		originalImg, err := args.client.InspectImage(args.newImage)
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		cont, err := args.client.CreateContainer(docker.CreateContainerOptions{
+			Config: &docker.Config{Image: args.newImage},
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		defer args.client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID})
+		reader, writer := io.Pipe()
+		go func() {
+			writer.CloseWithError(args.client.ExportContainer(docker.ExportContainerOptions{
+				ID:           cont.ID,
+				OutputStream: writer,
+			}))
+		}()
+		squashed := fmt.Sprintf("%s-squashed", args.newImage)
+		err = args.client.ImportImage(docker.ImportImageOptions{
+			Repository:  squashed,
+			Source:      "-",
+			InputStream: reader,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		if err := restoreImageConfig(args.client, squashed, originalImg.Config); err != nil {
+			return nil, errors.Wrap(err, "")
+		}
+		args.unsquashedImage = args.newImage
+		args.newImage = squashed
+		return ctx.Previous, nil
+	},
+}
+
+// restoreImageConfig re-commits image with cfg stamped onto it, leaving its
+// (already squashed) filesystem untouched: it only creates a container from
+// image, commits it straight back over the same tag with Run: cfg, and
+// removes the throwaway container.
+func restoreImageConfig(client *docker.Client, image string, cfg *docker.Config) error {
+	cont, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: image},
+	})
+	if err != nil {
+		return err
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID})
+	_, err = client.CommitContainer(docker.CommitContainerOptions{
+		Container:  cont.ID,
+		Repository: image,
+		Run:        cfg,
+	})
+	return err
+}
+
+// pushSquashedImage pushes args.newImage when args.squash is set. If the
+// push fails, it falls back to pushing args.unsquashedImage instead and
+// keeps using it as newImage for the rest of the pipeline, leaving the
+// cache-from entries untouched either way.
+var pushSquashedImage = &action.Action{
+	Name: "push-squashed-image",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(*pipelineArgs)
+		if buildah.Enabled() {
+			// buildahCommit already committed the image and pushed it
+			// through buildah.Push, there's nothing left to push here.
+			return ctx.Previous, nil
+		}
+		if !args.squash {
+			return ctx.Previous, nil
+		}
+		err := args.client.PushImage(docker.PushImageOptions{Name: args.newImage}, docker.AuthConfiguration{})
+		if err != nil {
+			log.Errorf("error pushing squashed image %s, falling back to %s: %+v", args.newImage, args.unsquashedImage, err)
+			err = args.client.PushImage(docker.PushImageOptions{Name: args.unsquashedImage}, docker.AuthConfiguration{})
+			if err != nil {
+				return nil, errors.Wrap(err, "")
+			}
+			args.newImage = args.unsquashedImage
+		}
+		return ctx.Previous, nil
+	},
+}
+
 var updateServices = &action.Action{
 	Name: "update-services",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
@@ -98,4 +340,27 @@ var removeOldServices = &action.Action{
 		}
 		return nil, nil
 	},
-}
\ No newline at end of file
+}
+
+// deployPipeline is the ordered list of actions a deploy runs through:
+// warming the build cache, committing the image via buildah when
+// build:backend=buildah (or optionally squashing it and pushing through
+// docker otherwise, falling back to the unsquashed image on push
+// failure), updating the swarm services, recording the new image and
+// cleaning up the processes that no longer exist.
+var deployPipeline = action.NewPipeline(
+	pullCacheFrom,
+	buildahCommit,
+	squashImage,
+	pushSquashedImage,
+	updateServices,
+	updateImageInDB,
+	removeOldServices,
+)
+
+// RunDeploy executes deployPipeline for args, pulling cacheFrom images and
+// squashing the image per args.squash/args.cacheFrom before updating the
+// app's services.
+func RunDeploy(args *pipelineArgs) error {
+	return deployPipeline.Execute(args)
+}